@@ -0,0 +1,137 @@
+package otpgateway
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stable, machine-readable error codes. Callers should match on Code
+// rather than pattern-matching Message, which is free to change.
+const (
+	CodeOTPMismatch      = "otp_mismatch"
+	CodeOTPLocked        = "otp_locked"
+	CodeOTPExpired       = "otp_expired"
+	CodeProviderUnknown  = "provider_unknown"
+	CodeAddressInvalid   = "address_invalid"
+	CodeIDTooShort       = "id_too_short"
+	CodeAttemptsExceeded = "attempts_exceeded"
+	CodeOTPRequired      = "otp_required"
+	CodeTokenRequired    = "token_required"
+	CodeTokenInvalid     = "token_invalid"
+	CodeWebhooksDisabled = "webhooks_disabled"
+	CodeUnauthorized     = "unauthorized"
+)
+
+// APIError is a machine-readable error that both HTTP handlers and
+// library callers can match on via errors.As, instead of pattern-matching
+// English error strings.
+type APIError struct {
+	HTTPStatus int         `json:"-"`
+	Code       string      `json:"code"`
+	Message    string      `json:"message"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// AttemptsDetails carries the attempt/TTL context attached to
+// mismatch and lockout errors.
+type AttemptsDetails struct {
+	Attempts    int     `json:"attempts"`
+	MaxAttempts int     `json:"max_attempts"`
+	TTLSeconds  float64 `json:"ttl_seconds"`
+}
+
+// ErrNotExist is returned when a namespace+ID OTP combination, or a
+// namespaced key, doesn't exist in the store, either because it never
+// existed or has expired.
+var ErrNotExist = &APIError{
+	HTTPStatus: 400,
+	Code:       CodeOTPExpired,
+	Message:    "OTP does not exist or has expired",
+}
+
+// ErrOTPMismatch indicates the presented OTP/code didn't match what's on
+// record.
+func ErrOTPMismatch(attempts, maxAttempts int, ttl time.Duration) *APIError {
+	return &APIError{
+		HTTPStatus: 400,
+		Code:       CodeOTPMismatch,
+		Message:    "OTP does not match",
+		Details:    AttemptsDetails{Attempts: attempts, MaxAttempts: maxAttempts, TTLSeconds: ttl.Seconds()},
+	}
+}
+
+// ErrLocked indicates the OTP has exceeded its maximum attempts and is
+// temporarily locked.
+func ErrLocked(attempts, maxAttempts int, ttl time.Duration) *APIError {
+	return &APIError{
+		HTTPStatus: 400,
+		Code:       CodeOTPLocked,
+		Message:    fmt.Sprintf("Too many attempts. Please retry after %0.f seconds.", ttl.Seconds()),
+		Details:    AttemptsDetails{Attempts: attempts, MaxAttempts: maxAttempts, TTLSeconds: ttl.Seconds()},
+	}
+}
+
+// ErrProviderUnknown indicates the requested provider isn't registered.
+func ErrProviderUnknown() *APIError {
+	return &APIError{HTTPStatus: 400, Code: CodeProviderUnknown, Message: "unknown provider"}
+}
+
+// ErrAddressInvalid indicates the `to` address was rejected by the
+// provider.
+func ErrAddressInvalid(reason string) *APIError {
+	return &APIError{
+		HTTPStatus: 400,
+		Code:       CodeAddressInvalid,
+		Message:    fmt.Sprintf("invalid `to` address: %s", reason),
+	}
+}
+
+// ErrIDTooShort indicates the caller-supplied ID is below the minimum
+// length.
+func ErrIDTooShort() *APIError {
+	return &APIError{HTTPStatus: 400, Code: CodeIDTooShort, Message: "ID should be min 6 chars"}
+}
+
+// ErrAttemptsExceeded indicates a new OTP can't be set because the
+// existing one is still locked out.
+func ErrAttemptsExceeded(attempts, maxAttempts int, ttl time.Duration) *APIError {
+	return &APIError{
+		HTTPStatus: 400,
+		Code:       CodeAttemptsExceeded,
+		Message:    fmt.Sprintf("OTP attempts exceeded. Retry after %0.f seconds.", ttl.Seconds()),
+		Details:    AttemptsDetails{Attempts: attempts, MaxAttempts: maxAttempts, TTLSeconds: ttl.Seconds()},
+	}
+}
+
+// ErrOTPRequired indicates the caller didn't supply an `otp` value to
+// check.
+func ErrOTPRequired() *APIError {
+	return &APIError{HTTPStatus: 400, Code: CodeOTPRequired, Message: "`otp` is empty"}
+}
+
+// ErrTokenRequired indicates the caller didn't supply a receipt `token`
+// to verify.
+func ErrTokenRequired() *APIError {
+	return &APIError{HTTPStatus: 400, Code: CodeTokenRequired, Message: "`token` is empty"}
+}
+
+// ErrTokenInvalid indicates a receipt token failed signature, namespace
+// or expiry verification.
+func ErrTokenInvalid(reason string) *APIError {
+	return &APIError{HTTPStatus: 401, Code: CodeTokenInvalid, Message: reason}
+}
+
+// ErrWebhooksDisabled indicates the namespace has no webhook configured,
+// so there is nothing to replay a dead letter against.
+func ErrWebhooksDisabled() *APIError {
+	return &APIError{HTTPStatus: 400, Code: CodeWebhooksDisabled, Message: "no webhook is configured for this namespace"}
+}
+
+// ErrUnauthorized indicates the request's Authenticator rejected it.
+func ErrUnauthorized(reason string) *APIError {
+	return &APIError{HTTPStatus: 401, Code: CodeUnauthorized, Message: reason}
+}
@@ -0,0 +1,26 @@
+package otpgateway
+
+import "time"
+
+// Lifecycle events fired as webhooks as an OTP moves through its
+// verification flow.
+const (
+	EventOTPCreated     = "otp.created"
+	EventOTPResent      = "otp.resent"
+	EventOTPCheckFailed = "otp.check_failed"
+	EventOTPVerified    = "otp.verified"
+	EventOTPLocked      = "otp.locked"
+)
+
+// DeadLetter is a webhook delivery that exhausted its retry budget. It's
+// parked per namespace so an operator can inspect or replay it later.
+type DeadLetter struct {
+	ID        string    `json:"id"`
+	Namespace string    `json:"namespace"`
+	Event     string    `json:"event"`
+	URL       string    `json:"url"`
+	Body      string    `json:"body"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
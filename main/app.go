@@ -0,0 +1,43 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"time"
+
+	"github.com/knadh/otpgateway"
+)
+
+var logger = log.New(os.Stdout, "otpgateway: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+// providerTpl holds the parsed subject and body templates used to render
+// the message pushed out to a Provider.
+type providerTpl struct {
+	subject *template.Template
+	tpl     *template.Template
+}
+
+// App is the central, shared state injected into every HTTP handler.
+type App struct {
+	logger *log.Logger
+	tpl    *template.Template
+
+	providers    map[string]otpgateway.Provider
+	providerTpls map[string]*providerTpl
+
+	store otpgateway.Store
+
+	otpTTL         time.Duration
+	otpMaxAttempts int
+
+	// signingKeys manages the per-namespace RSA keypairs used to sign and
+	// verify JWT verification receipts.
+	signingKeys *keyRing
+
+	// webhooks dispatches HMAC-signed lifecycle events to namespace
+	// callback URLs. It's nil if no namespace has one configured.
+	webhooks *WebhookDispatcher
+
+	RootURL string
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authenticator is a pluggable request authentication strategy. It
+// inspects an incoming request's Authorization header and, if valid,
+// returns the namespace it authenticated as.
+type Authenticator interface {
+	Authenticate(r *http.Request) (namespace string, err error)
+}
+
+// MultiAuthenticator dispatches an incoming request to the right
+// strategy based on its Authorization scheme, then confirms the
+// authenticated namespace is actually configured to use that scheme.
+// This is how different tenants can be pinned to different auth schemes
+// in config while a single middleware fronts every route.
+type MultiAuthenticator struct {
+	Basic  *BasicAuthenticator
+	HMAC   *HMACAuthenticator
+	Bearer *BearerAuthenticator
+
+	// Schemes maps a namespace to the scheme it's allowed to authenticate
+	// with: "basic", "hmac" or "bearer".
+	Schemes map[string]string
+}
+
+func (m *MultiAuthenticator) Authenticate(r *http.Request) (string, error) {
+	h := r.Header.Get("Authorization")
+
+	var (
+		namespace, scheme string
+		err               error
+	)
+	switch {
+	case strings.HasPrefix(h, "Basic "):
+		scheme = "basic"
+		namespace, err = m.Basic.Authenticate(r)
+	case strings.HasPrefix(h, "HMAC "):
+		scheme = "hmac"
+		namespace, err = m.HMAC.Authenticate(r)
+	case strings.HasPrefix(h, "Bearer "):
+		scheme = "bearer"
+		namespace, err = m.Bearer.Authenticate(r)
+	default:
+		return "", fmt.Errorf("missing or unsupported Authorization header")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if want, ok := m.Schemes[namespace]; !ok || want != scheme {
+		return "", fmt.Errorf("namespace %q is not configured for %s authentication", namespace, scheme)
+	}
+	return namespace, nil
+}
+
+// BasicAuthenticator implements HTTP Basic auth where the username is the
+// namespace and the password is its shared secret.
+type BasicAuthenticator struct {
+	Creds map[string]string
+}
+
+func (b *BasicAuthenticator) Authenticate(r *http.Request) (string, error) {
+	namespace, secret, ok := r.BasicAuth()
+	if !ok {
+		return "", fmt.Errorf("invalid or missing Basic Authorization header")
+	}
+
+	key, ok := b.Creds[namespace]
+	if !ok || key != secret {
+		return "", fmt.Errorf("invalid API credentials")
+	}
+	return namespace, nil
+}
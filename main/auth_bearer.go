@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BearerConfig describes how a single namespace's Bearer tokens are
+// verified: either locally against a JWKS URL, or remotely via an
+// RFC 7662 introspection endpoint.
+type BearerConfig struct {
+	JWKSURL string
+
+	IntrospectionURL   string
+	IntrospectionToken string
+
+	// Claim names the JWT/introspection claim that carries the
+	// namespace, e.g. "namespace" or "aud".
+	Claim string
+}
+
+// jwksCacheTTL bounds how long a fetched JWKS document is trusted before
+// it's refetched, so a rotated signing key is picked up without a restart.
+const jwksCacheTTL = 10 * time.Minute
+
+// jwksCacheEntry is a fetched JWKS document along with when it was fetched.
+type jwksCacheEntry struct {
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// BearerAuthenticator verifies a JWT locally against a configured JWKS
+// URL, or calls an RFC 7662 introspection endpoint, per namespace.
+type BearerAuthenticator struct {
+	Namespaces map[string]BearerConfig
+	Client     *http.Client
+
+	mu   sync.Mutex
+	jwks map[string]jwksCacheEntry // JWKS URL -> cached keys
+}
+
+// NewBearerAuthenticator returns a BearerAuthenticator ready for use.
+func NewBearerAuthenticator(namespaces map[string]BearerConfig) *BearerAuthenticator {
+	return &BearerAuthenticator{
+		Namespaces: namespaces,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+		jwks:       make(map[string]jwksCacheEntry),
+	}
+}
+
+func (b *BearerAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", fmt.Errorf("missing Bearer token")
+	}
+
+	claims, err := peekJWTClaims(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid bearer token: %v", err)
+	}
+
+	for namespace, cfg := range b.Namespaces {
+		if claimStr(claims, cfg.Claim) != namespace {
+			continue
+		}
+
+		switch {
+		case cfg.JWKSURL != "":
+			if err := b.verifyJWKS(cfg, token, claims); err != nil {
+				return "", err
+			}
+		case cfg.IntrospectionURL != "":
+			active, err := b.introspect(cfg, token)
+			if err != nil {
+				return "", err
+			}
+			if !active {
+				return "", fmt.Errorf("bearer token is not active")
+			}
+		default:
+			return "", fmt.Errorf("namespace %q has no Bearer verification method configured", namespace)
+		}
+		return namespace, nil
+	}
+
+	return "", fmt.Errorf("bearer token does not map to a known namespace")
+}
+
+// verifyJWKS verifies the token's signature against the namespace's JWKS
+// URL and checks its expiry.
+func (b *BearerAuthenticator) verifyJWKS(cfg BearerConfig, token string, claims map[string]interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed bearer token")
+	}
+
+	header, err := decodeJSONSegment(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid bearer token header: %v", err)
+	}
+	kid, _ := header["kid"].(string)
+
+	key, err := b.jwksKey(cfg.JWKSURL, kid)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyRS256(key, parts[0]+"."+parts[1], parts[2]); err != nil {
+		return fmt.Errorf("bearer token signature is invalid")
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
+		return fmt.Errorf("bearer token has expired")
+	}
+	return nil
+}
+
+// jwksKey returns the public key for kid, fetching and caching the JWKS
+// document on first use, refreshing it once it's past jwksCacheTTL, and
+// refetching on a cache-miss kid in case the IdP just rotated its key.
+func (b *BearerAuthenticator) jwksKey(jwksURL, kid string) (*rsa.PublicKey, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.jwks[jwksURL]
+	if !ok || time.Since(entry.fetchedAt) > jwksCacheTTL {
+		if err := b.refreshJWKS(jwksURL, &entry); err != nil {
+			return nil, err
+		}
+	}
+
+	key, ok := entry.keys[kid]
+	if !ok {
+		// The cached document may be stale relative to a key rotation
+		// that happened within the TTL window; refetch once before
+		// giving up.
+		if err := b.refreshJWKS(jwksURL, &entry); err != nil {
+			return nil, err
+		}
+		key, ok = entry.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("no matching key for kid %q in JWKS", kid)
+		}
+	}
+	return key, nil
+}
+
+// refreshJWKS fetches jwksURL, stores the result in both the cache and
+// entry, and must be called with b.mu held.
+func (b *BearerAuthenticator) refreshJWKS(jwksURL string, entry *jwksCacheEntry) error {
+	keys, err := b.fetchJWKS(jwksURL)
+	if err != nil {
+		return err
+	}
+	*entry = jwksCacheEntry{keys: keys, fetchedAt: time.Now()}
+	b.jwks[jwksURL] = *entry
+	return nil
+}
+
+func (b *BearerAuthenticator) fetchJWKS(jwksURL string) (map[string]*rsa.PublicKey, error) {
+	resp, err := b.Client.Get(jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var doc struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding JWKS: %v", err)
+	}
+
+	out := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		out[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return out, nil
+}
+
+// introspect calls the namespace's RFC 7662 introspection endpoint.
+func (b *BearerAuthenticator) introspect(cfg BearerConfig, token string) (bool, error) {
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequest(http.MethodPost, cfg.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cfg.IntrospectionToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.IntrospectionToken)
+	}
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error calling introspection endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, fmt.Errorf("error decoding introspection response: %v", err)
+	}
+	return out.Active, nil
+}
+
+// peekJWTClaims decodes a JWT's payload without verifying its signature,
+// just far enough to route the request to the right verification method.
+func peekJWTClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed bearer token")
+	}
+	return decodeJSONSegment(parts[1])
+}
+
+func decodeJSONSegment(seg string) (map[string]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func claimStr(claims map[string]interface{}, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signedTestJWT mints a RS256 JWT with the given claims, signed by key,
+// for exercising BearerAuthenticator against a local JWKS fixture.
+func signedTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	assert.NoError(t, err)
+	body, err := json.Marshal(claims)
+	assert.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	assert.NoError(t, err)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestBearerAuthenticateJWKSFixture(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "generating test key failed")
+
+	const kid = "test-key"
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(bigIntBytes(key.PublicKey.E)),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	b := NewBearerAuthenticator(map[string]BearerConfig{
+		dummyNamespace: {JWKSURL: jwks.URL, Claim: "namespace"},
+	})
+
+	token := signedTestJWT(t, key, kid, map[string]interface{}{
+		"namespace": dummyNamespace,
+		"exp":       float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	ns, err := b.Authenticate(r)
+	assert.NoError(t, err, "a validly signed bearer token should authenticate")
+	assert.Equal(t, dummyNamespace, ns, "namespace doesn't match")
+}
+
+func TestBearerAuthenticateRejectsExpired(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "generating test key failed")
+
+	const kid = "test-key"
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(bigIntBytes(key.PublicKey.E)),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	b := NewBearerAuthenticator(map[string]BearerConfig{
+		dummyNamespace: {JWKSURL: jwks.URL, Claim: "namespace"},
+	})
+
+	token := signedTestJWT(t, key, kid, map[string]interface{}{
+		"namespace": dummyNamespace,
+		"exp":       float64(time.Now().Add(-time.Hour).Unix()),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	_, err = b.Authenticate(r)
+	assert.Error(t, err, "an expired bearer token should be rejected")
+}
+
+func bigIntBytes(e int) []byte {
+	b := make([]byte, 0, 4)
+	for e > 0 {
+		b = append([]byte{byte(e & 0xff)}, b...)
+		e >>= 8
+	}
+	return b
+}
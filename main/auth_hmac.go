@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HMACAuthenticator implements request signing: the client signs
+// "METHOD\nPATH\nBODY_SHA256\nTIMESTAMP\nNONCE" with the namespace's
+// shared secret and sends it as:
+//
+//	Authorization: HMAC <namespace>:<timestamp>:<nonce>:<signature>
+//
+// Requests outside SkewWindow of the current time, or whose nonce has
+// already been seen, are rejected to close the replay window.
+type HMACAuthenticator struct {
+	Secrets    map[string]string
+	SkewWindow time.Duration
+
+	mu    sync.Mutex
+	nonce map[string]time.Time
+}
+
+// NewHMACAuthenticator returns an HMACAuthenticator ready for use.
+func NewHMACAuthenticator(secrets map[string]string, skew time.Duration) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		Secrets:    secrets,
+		SkewWindow: skew,
+		nonce:      make(map[string]time.Time),
+	}
+}
+
+func (h *HMACAuthenticator) Authenticate(r *http.Request) (string, error) {
+	const prefix = "HMAC "
+
+	val := strings.TrimPrefix(r.Header.Get("Authorization"), prefix)
+	parts := strings.SplitN(val, ":", 4)
+	if len(parts) != 4 {
+		return "", fmt.Errorf("invalid HMAC Authorization header")
+	}
+	namespace, tsRaw, nonce, sig := parts[0], parts[1], parts[2], parts[3]
+
+	secret, ok := h.Secrets[namespace]
+	if !ok {
+		return "", fmt.Errorf("invalid API credentials")
+	}
+
+	ts, err := strconv.ParseInt(tsRaw, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid HMAC timestamp")
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > h.SkewWindow {
+		return "", fmt.Errorf("HMAC timestamp outside the allowed clock skew")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading request body: %v", err)
+	}
+	r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+
+	bodyHash := sha256.Sum256(body)
+	signingInput := strings.Join([]string{
+		r.Method,
+		r.URL.Path,
+		hex.EncodeToString(bodyHash[:]),
+		tsRaw,
+		nonce,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return "", fmt.Errorf("HMAC signature does not match")
+	}
+
+	// Only a validly-signed request can burn a nonce: checking this
+	// before the signature is verified would let an attacker who can
+	// merely observe or guess a namespace+timestamp+nonce triple (no
+	// secret required) permanently block the legitimate request from
+	// ever succeeding with it.
+	if h.seen(namespace, nonce) {
+		return "", fmt.Errorf("HMAC nonce has already been used")
+	}
+
+	return namespace, nil
+}
+
+// seen records a namespace+nonce pair, returning true if it had already
+// been seen (and should therefore be rejected as a replay).
+func (h *HMACAuthenticator) seen(namespace, nonce string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// A request timestamped up to SkewWindow in the future stays acceptable
+	// until up to 2*SkewWindow after its nonce was first seen, so nonces are
+	// kept that long.
+	now := time.Now()
+	for k, t := range h.nonce {
+		if now.Sub(t) > 2*h.SkewWindow {
+			delete(h.nonce, k)
+		}
+	}
+
+	key := namespace + ":" + nonce
+	if _, ok := h.nonce[key]; ok {
+		return true
+	}
+	h.nonce[key] = now
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signHMACRequest signs a request the way a client is expected to, and
+// sets its Authorization header.
+func signHMACRequest(t *testing.T, secret string, r *http.Request, ts time.Time, nonce string) {
+	t.Helper()
+
+	bodyHash := sha256.Sum256(nil)
+	tsRaw := strconv.FormatInt(ts.Unix(), 10)
+	signingInput := strings.Join([]string{
+		r.Method,
+		r.URL.Path,
+		hex.EncodeToString(bodyHash[:]),
+		tsRaw,
+		nonce,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	r.Header.Set("Authorization", "HMAC "+strings.Join([]string{dummyNamespace, tsRaw, nonce, sig}, ":"))
+}
+
+func TestHMACAuthenticateValid(t *testing.T) {
+	h := NewHMACAuthenticator(map[string]string{dummyNamespace: dummySecret}, 5*time.Minute)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	signHMACRequest(t, dummySecret, r, time.Now(), "nonce-1")
+
+	ns, err := h.Authenticate(r)
+	assert.NoError(t, err, "a validly signed request should authenticate")
+	assert.Equal(t, dummyNamespace, ns, "namespace doesn't match")
+}
+
+func TestHMACAuthenticateRejectsReplayedNonce(t *testing.T) {
+	h := NewHMACAuthenticator(map[string]string{dummyNamespace: dummySecret}, 5*time.Minute)
+
+	r1 := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	signHMACRequest(t, dummySecret, r1, time.Now(), "nonce-reused")
+	_, err := h.Authenticate(r1)
+	assert.NoError(t, err, "first use of the nonce should authenticate")
+
+	r2 := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	signHMACRequest(t, dummySecret, r2, time.Now(), "nonce-reused")
+	_, err = h.Authenticate(r2)
+	assert.Error(t, err, "replaying the same nonce should be rejected")
+}
+
+func TestHMACAuthenticateForgedRequestDoesNotBurnNonce(t *testing.T) {
+	h := NewHMACAuthenticator(map[string]string{dummyNamespace: dummySecret}, 5*time.Minute)
+
+	// An unsigned, forged request using a guessed namespace+timestamp+nonce
+	// triple must fail on signature, not burn the nonce.
+	ts := time.Now()
+	tsRaw := strconv.FormatInt(ts.Unix(), 10)
+	forged := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	forged.Header.Set("Authorization", "HMAC "+strings.Join([]string{dummyNamespace, tsRaw, "shared-nonce", "deadbeef"}, ":"))
+	_, err := h.Authenticate(forged)
+	assert.Error(t, err, "forged signature should be rejected")
+
+	// The legitimate, validly signed request using the same nonce must
+	// still succeed.
+	legit := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	signHMACRequest(t, dummySecret, legit, ts, "shared-nonce")
+	_, err = h.Authenticate(legit)
+	assert.NoError(t, err, "a forged prior attempt must not burn the nonce for the legitimate request")
+}
+
+func TestHMACAuthenticateRejectsStaleTimestamp(t *testing.T) {
+	h := NewHMACAuthenticator(map[string]string{dummyNamespace: dummySecret}, time.Minute)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/otp/"+dummyOTPID, nil)
+	signHMACRequest(t, dummySecret, r, time.Now().Add(-time.Hour), "nonce-stale")
+
+	_, err := h.Authenticate(r)
+	assert.Error(t, err, "a timestamp outside the skew window should be rejected")
+}
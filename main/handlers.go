@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/knadh/otpgateway"
@@ -24,7 +23,7 @@ const (
 	actResend = "resend"
 
 	uriView  = "/otp/%s/%s"
-	uriCheck = "/otp/%s/%s?otp=%s&action=check"
+	uriCheck = "/otp/%s/%s?nonce=%s&action=check"
 )
 
 type httpResp struct {
@@ -36,12 +35,28 @@ type httpResp struct {
 type otpResp struct {
 	otpgateway.OTP
 	URL string `json:"url"`
+
+	// ProvisioningURI and QR are only set for otp_kind=totp|hotp, letting
+	// the caller render the otpauth:// enrollment as a scannable code.
+	ProvisioningURI string `json:"provisioning_uri,omitempty"`
+	QR              string `json:"qr,omitempty"`
+}
+
+type checkResp struct {
+	Verified bool   `json:"verified"`
+	Receipt  string `json:"receipt"`
+}
+
+// errResp is the JSON envelope written by sendErrorResponse.
+type errResp struct {
+	Status string  `json:"status"`
+	Error  errBody `json:"error"`
 }
 
-type otpErrResp struct {
-	TTL         float64 `json:"ttl_seconds"`
-	Attempts    int     `json:"attempts"`
-	MaxAttempts int     `json:"max_attempts"`
+type errBody struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 type tpl struct {
@@ -54,6 +69,7 @@ type tpl struct {
 	Locked      bool
 	Closed      bool
 	Message     string
+	Code        string
 
 	App *App
 }
@@ -91,91 +107,120 @@ func handleSetOTP(w http.ResponseWriter, r *http.Request) {
 		description = r.FormValue("description")
 		to          = r.FormValue("to")
 		otpVal      = r.FormValue("otp")
+		kind        = r.FormValue("kind")
 	)
+	if kind == "" {
+		kind = otpgateway.KindRandom
+	}
 
 	// Get the provider.
 	pro, ok := app.providers[provider]
 	if !ok {
-		sendErrorResponse(w, "unknown provider", http.StatusBadRequest, nil)
+		sendErrorResponse(w, otpgateway.ErrProviderUnknown())
 		return
 	}
 
 	// Validate the 'to' address with the provider.
 	if err := pro.ValidateAddress(to); err != nil {
-		sendErrorResponse(w, fmt.Sprintf("invalid `to` address: %v", err),
-			http.StatusBadRequest, nil)
+		sendErrorResponse(w, otpgateway.ErrAddressInvalid(err.Error()))
 		return
 	}
 
 	// If there is no incoming ID, generate a random ID.
 	if len(id) < 6 {
-		sendErrorResponse(w, "ID should be min 6 chars", http.StatusBadRequest, nil)
+		sendErrorResponse(w, otpgateway.ErrIDTooShort())
 		return
 	} else if id == "" {
 		if i, err := generateRandomString(32, alphaNumChars); err != nil {
 			app.logger.Printf("error generating ID: %v", err)
-			sendErrorResponse(w, "error generating ID", http.StatusInternalServerError, nil)
+			sendErrorResponse(w, fmt.Errorf("error generating ID: %v", err))
 			return
 		} else {
 			id = i
 		}
 	}
 
-	// If there's no incoming OTP, generate a random one.
-	if otpVal == "" {
-		o, err := generateRandomString(pro.MaxOTPLen(), numChars)
+	newOTPIn := otpgateway.OTP{
+		To:          to,
+		Description: description,
+		Provider:    provider,
+		TTL:         app.otpTTL,
+		MaxAttempts: app.otpMaxAttempts,
+		OTPKind:     kind,
+	}
+
+	switch kind {
+	case otpgateway.KindTOTP, otpgateway.KindHOTP:
+		// Provision a shared secret instead of a one-shot random value;
+		// the code itself is computed by the authenticator app.
+		secret, err := generateSecret()
 		if err != nil {
-			app.logger.Printf("error generating OTP: %v", err)
-			sendErrorResponse(w, "error generating OTP", http.StatusInternalServerError, nil)
+			app.logger.Printf("error generating TOTP/HOTP secret: %v", err)
+			sendErrorResponse(w, fmt.Errorf("error generating secret: %v", err))
 			return
 		}
-		otpVal = o
+		newOTPIn.Secret = secret
+		newOTPIn.Digits = pro.MaxOTPLen()
+		newOTPIn.Algo = otpgateway.AlgoSHA1
+		if kind == otpgateway.KindTOTP {
+			newOTPIn.Period = 30
+		}
+	default:
+		// If there's no incoming OTP, generate a random one.
+		if otpVal == "" {
+			o, err := generateRandomString(pro.MaxOTPLen(), numChars)
+			if err != nil {
+				app.logger.Printf("error generating OTP: %v", err)
+				sendErrorResponse(w, fmt.Errorf("error generating OTP: %v", err))
+				return
+			}
+			otpVal = o
+		}
+		newOTPIn.OTP = otpVal
 	}
 
 	// Check if the OTP attempts have exceeded the quota.
 	otp, err := app.store.Check(namespace, id, false)
 	if err != nil && err != otpgateway.ErrNotExist {
 		app.logger.Printf("error checking OTP status: %v", err)
-		sendErrorResponse(w, "error checking OTP status", http.StatusBadRequest, nil)
+		sendErrorResponse(w, fmt.Errorf("error checking OTP status: %v", err))
 		return
 	}
 
 	// There's an existing OTP that's locked.
 	if err != otpgateway.ErrNotExist && isLocked(otp) {
-		sendErrorResponse(w,
-			fmt.Sprintf("OTP attempts exceeded. Retry after %0.f seconds.",
-				otp.TTL.Seconds()),
-			http.StatusBadRequest, otpErrResp{
-				Attempts:    otp.Attempts,
-				MaxAttempts: app.otpMaxAttempts,
-				TTL:         otp.TTL.Seconds(),
-			})
+		sendErrorResponse(w, otpgateway.ErrAttemptsExceeded(otp.Attempts, app.otpMaxAttempts, otp.TTL))
 		return
 	}
 
 	// Create the OTP.
-	newOTP, err := app.store.Set(namespace, id, otpgateway.OTP{
-		OTP:         otpVal,
-		To:          to,
-		Description: description,
-		Provider:    provider,
-		TTL:         app.otpTTL,
-		MaxAttempts: app.otpMaxAttempts,
-	})
+	newOTP, err := app.store.Set(namespace, id, newOTPIn)
 	if err != nil {
 		app.logger.Printf("error setting OTP: %v", err)
-		sendErrorResponse(w, "error setting OTP", http.StatusInternalServerError, nil)
+		sendErrorResponse(w, fmt.Errorf("error setting OTP: %v", err))
 		return
 	}
 
-	// Push the OTP out.
-	if err := push(newOTP, app.providerTpls[pro.ID()], pro, app.RootURL); err != nil {
+	out := otpResp{OTP: newOTP, URL: getURL(app.RootURL, newOTP, false)}
+
+	if kind == otpgateway.KindTOTP || kind == otpgateway.KindHOTP {
+		uri := provisioningURI(app.RootURL, newOTP)
+		qr, err := provisioningQR(uri)
+		if err != nil {
+			app.logger.Printf("error rendering provisioning QR: %v", err)
+			sendErrorResponse(w, fmt.Errorf("error rendering provisioning QR: %v", err))
+			return
+		}
+		out.ProvisioningURI = uri
+		out.QR = qr
+	} else if err := push(newOTP, app.providerTpls[pro.ID()], pro, app.RootURL); err != nil {
 		app.logger.Printf("error sending OTP: %v", err)
-		sendErrorResponse(w, "error sending OTP", http.StatusInternalServerError, nil)
+		sendErrorResponse(w, fmt.Errorf("error sending OTP: %v", err))
 		return
 	}
 
-	out := otpResp{newOTP, getURL(app.RootURL, newOTP, false)}
+	app.webhooks.Emit(namespace, otpgateway.EventOTPCreated, newOTP)
+
 	sendResponse(w, out)
 }
 
@@ -189,17 +234,115 @@ func handleCheckOTP(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if len(id) < 6 {
-		sendErrorResponse(w, "ID should be min 6 chars", http.StatusBadRequest, nil)
+		sendErrorResponse(w, otpgateway.ErrIDTooShort())
 		return
 	}
 	if otpVal == "" {
-		sendErrorResponse(w, "`otp` is empty", http.StatusBadRequest, nil)
+		sendErrorResponse(w, otpgateway.ErrOTPRequired())
 		return
 	}
 
 	out, err := checkOTP(namespace, id, otpVal, app)
 	if err != nil {
-		sendErrorResponse(w, err.Error(), http.StatusBadRequest, out)
+		sendErrorResponse(w, err)
+		return
+	}
+
+	receipt, err := newReceipt(app, out)
+	if err != nil {
+		app.logger.Printf("error minting verification receipt: %v", err)
+		sendErrorResponse(w, fmt.Errorf("error minting verification receipt: %v", err))
+		return
+	}
+
+	sendResponse(w, checkResp{Verified: true, Receipt: receipt})
+}
+
+// handleVerifyReceipt verifies a JWT verification receipt minted by
+// handleCheckOTP and returns its decoded claims.
+func handleVerifyReceipt(w http.ResponseWriter, r *http.Request) {
+	var (
+		app       = r.Context().Value("app").(*App)
+		namespace = r.Context().Value("namespace").(string)
+		token     = r.FormValue("token")
+	)
+
+	if token == "" {
+		sendErrorResponse(w, otpgateway.ErrTokenRequired())
+		return
+	}
+
+	claims, err := verifyReceipt(app, namespace, token)
+	if err != nil {
+		sendErrorResponse(w, otpgateway.ErrTokenInvalid(err.Error()))
+		return
+	}
+
+	sendResponse(w, claims)
+}
+
+// handleJWKS returns the JWK set for every namespace whose signing key has
+// been generated so far, letting relying parties verify receipt signatures
+// without sharing a secret.
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	app := r.Context().Value("app").(*App)
+
+	namespaces, err := app.signingKeys.namespaces()
+	if err != nil {
+		app.logger.Printf("error listing signing key namespaces: %v", err)
+		sendErrorResponse(w, fmt.Errorf("error listing signing key namespaces: %v", err))
+		return
+	}
+
+	keys := make([]map[string]string, 0)
+	for _, ns := range namespaces {
+		jwk, err := app.signingKeys.jwk(ns)
+		if err != nil {
+			app.logger.Printf("error building JWK for namespace %s: %v", ns, err)
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// handleListDeadLetters returns a namespace's webhook deliveries that
+// exhausted their retry budget.
+func handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var (
+		app       = r.Context().Value("app").(*App)
+		namespace = r.Context().Value("namespace").(string)
+	)
+
+	letters, err := app.store.ListDeadLetters(namespace)
+	if err != nil {
+		app.logger.Printf("error listing dead-lettered webhooks: %v", err)
+		sendErrorResponse(w, fmt.Errorf("error listing dead-lettered webhooks: %v", err))
+		return
+	}
+
+	sendResponse(w, letters)
+}
+
+// handleReplayDeadLetter re-sends a parked webhook delivery and, on
+// success, removes it from the dead-letter list.
+func handleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	var (
+		app       = r.Context().Value("app").(*App)
+		namespace = r.Context().Value("namespace").(string)
+		id        = chi.URLParam(r, "id")
+	)
+
+	if app.webhooks == nil {
+		sendErrorResponse(w, otpgateway.ErrWebhooksDisabled())
+		return
+	}
+
+	if err := app.webhooks.replay(namespace, id); err != nil {
+		app.logger.Printf("error replaying dead-lettered webhook %s: %v", id, err)
+		sendErrorResponse(w, fmt.Errorf("error replaying webhook: %v", err))
 		return
 	}
 
@@ -214,6 +357,7 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		action    = r.FormValue("action")
 		id        = chi.URLParam(r, "id")
 		otp       = r.FormValue("otp")
+		nonce     = r.FormValue("nonce")
 
 		out    otpgateway.OTP
 		otpErr error
@@ -223,10 +367,14 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		// Render the view without incrementing attempts.
 		out, otpErr = app.store.Check(namespace, id, false)
 	} else if action == actResend {
-		// Fetch the OTP for resending.
-		out, otpErr = app.store.Check(namespace, id, true)
+		// Rotate the nonce so the previously pushed check link can't be
+		// replayed, then fetch the rotated OTP for resending.
+		out, otpErr = app.store.Rotate(namespace, id)
+	} else if nonce != "" {
+		// A check link was clicked; validate its single-use nonce.
+		out, otpErr = checkOTPNonce(namespace, id, nonce, app)
 	} else {
-		// Validate the attempt.
+		// The OTP was typed into the form; validate it directly.
 		out, otpErr = checkOTP(namespace, id, otp, app)
 	}
 	if otpErr == otpgateway.ErrNotExist {
@@ -270,24 +418,30 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	msg := ""
+	msg, code := "", ""
 	// It's a resend request.
 	if action == actResend {
 		msg = "OTP resent"
 		if err := push(out, app.providerTpls[pro.ID()], pro, app.RootURL); err != nil {
 			app.logger.Printf("error sending OTP: %v", err)
 			otpErr = errors.New("error resending the OTP")
+		} else {
+			app.webhooks.Emit(namespace, otpgateway.EventOTPResent, out)
 		}
 	}
 
 	if otpErr != nil {
 		msg = otpErr.Error()
+		if apiErr, ok := otpErr.(*otpgateway.APIError); ok {
+			code = apiErr.Code
+		}
 	}
 
 	app.tpl.ExecuteTemplate(w, "otp", tpl{App: app,
 		ChannelName: pro.ChannelName(),
 		MaxOTPLen:   pro.MaxOTPLen(),
 		Message:     msg,
+		Code:        code,
 		Title:       fmt.Sprintf("Verify %s", pro.ChannelName()),
 		Description: pro.Description(),
 		OTP:         out,
@@ -306,22 +460,60 @@ func checkOTP(namespace, id, otp string, app *App) (otpgateway.OTP, error) {
 		return out, err
 	}
 
-	errMsg := ""
-	if isLocked(out) {
-		errMsg = fmt.Sprintf("Too many attempts. Please retry after %0.f seconds.",
-			out.TTL.Seconds())
-	} else if out.OTP != otp {
-		errMsg = "OTP does not match"
+	switch {
+	case isLocked(out):
+		app.webhooks.Emit(namespace, otpgateway.EventOTPLocked, out)
+		return out, otpgateway.ErrLocked(out.Attempts, out.MaxAttempts, out.TTL)
+	case out.OTPKind == otpgateway.KindHOTP:
+		next, ok := otpgateway.CheckHOTP(out.Secret, out.Counter, out.Digits, out.Algo, hotpResyncWindow, otp)
+		if !ok {
+			app.webhooks.Emit(namespace, otpgateway.EventOTPCheckFailed, out)
+			return out, otpgateway.ErrOTPMismatch(out.Attempts, out.MaxAttempts, out.TTL)
+		}
+		if err := app.store.SetCounter(namespace, id, next); err != nil {
+			app.logger.Printf("error persisting HOTP counter: %v", err)
+		}
+	case out.OTPKind == otpgateway.KindTOTP:
+		if !otpgateway.CheckTOTP(out.Secret, time.Now().Unix(), 0, int64(out.Period),
+			out.Digits, totpSkewWindow, out.Algo, otp) {
+			app.webhooks.Emit(namespace, otpgateway.EventOTPCheckFailed, out)
+			return out, otpgateway.ErrOTPMismatch(out.Attempts, out.MaxAttempts, out.TTL)
+		}
+	case out.OTP != otp:
+		app.webhooks.Emit(namespace, otpgateway.EventOTPCheckFailed, out)
+		return out, otpgateway.ErrOTPMismatch(out.Attempts, out.MaxAttempts, out.TTL)
 	}
 
-	// There was an error.
-	if errMsg != "" {
-		return out, errors.New(errMsg)
+	app.store.Close(namespace, id)
+	out.Closed = true
+	app.webhooks.Emit(namespace, otpgateway.EventOTPVerified, out)
+	return out, nil
+}
+
+// checkOTPNonce validates a single-use nonce presented via a pushed check
+// link, so a click can verify without requiring the user to retype the OTP.
+func checkOTPNonce(namespace, id, nonce string, app *App) (otpgateway.OTP, error) {
+	out, err := app.store.Check(namespace, id, true)
+	if err != nil {
+		if err == otpgateway.ErrNotExist {
+			return out, err
+		}
+		app.logger.Printf("error checking OTP: %v", err)
+		return out, err
+	}
+
+	if isLocked(out) {
+		return out, otpgateway.ErrLocked(out.Attempts, out.MaxAttempts, out.TTL)
+	}
+	if out.Nonce != nonce {
+		mismatch := otpgateway.ErrOTPMismatch(out.Attempts, out.MaxAttempts, out.TTL)
+		mismatch.Message = "This verification link has expired or was already used"
+		return out, mismatch
 	}
 
 	app.store.Close(namespace, id)
 	out.Closed = true
-	return out, err
+	return out, nil
 }
 
 // wrap is a middleware that wraps HTTP handlers and injects the "app" context.
@@ -332,27 +524,40 @@ func wrap(app *App, next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
-// sendErrorResponse sends a JSON envelope to the HTTP response.
+// sendResponse sends a JSON success envelope to the HTTP response.
 func sendResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	out, err := json.Marshal(httpResp{Status: "success", Data: data})
 	if err != nil {
-		sendErrorResponse(w, "Internal Server Error", http.StatusInternalServerError, nil)
+		sendErrorResponse(w, err)
 		return
 	}
 
 	w.Write(out)
 }
 
-// sendErrorResponse sends a JSON error envelope to the HTTP response.
-func sendErrorResponse(w http.ResponseWriter, message string, code int, data interface{}) {
+// sendErrorResponse sends a JSON error envelope to the HTTP response. Errors
+// that are *otpgateway.APIError surface their stable Code, HTTP status and
+// Details; any other error is wrapped as a generic internal_error so callers
+// always get the same envelope shape.
+func sendErrorResponse(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*otpgateway.APIError)
+	if !ok {
+		apiErr = &otpgateway.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Code:       "internal_error",
+			Message:    err.Error(),
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(code)
+	w.WriteHeader(apiErr.HTTPStatus)
 
-	resp := httpResp{Status: "error",
-		Message: message,
-		Data:    data}
-	out, _ := json.Marshal(resp)
+	out, _ := json.Marshal(errResp{Status: "error", Error: errBody{
+		Code:    apiErr.Code,
+		Message: apiErr.Message,
+		Details: apiErr.Details,
+	}})
 
 	w.Write(out)
 }
@@ -405,57 +610,23 @@ func push(otp otpgateway.OTP, tpl *providerTpl, p otpgateway.Provider, rootURL s
 
 func getURL(rootURL string, otp otpgateway.OTP, check bool) string {
 	if check {
-		return rootURL + fmt.Sprintf(uriCheck, otp.Namespace, otp.ID, otp.OTP)
+		return rootURL + fmt.Sprintf(uriCheck, otp.Namespace, otp.ID, otp.Nonce)
 	}
 	return rootURL + fmt.Sprintf(uriView, otp.Namespace, otp.ID)
 }
 
-// auth is a simple authentication middleware.
-func auth(authMap map[string]string, next http.HandlerFunc) http.HandlerFunc {
+// auth is an authentication middleware that delegates to a pluggable
+// Authenticator strategy and injects the namespace it authenticated into
+// the request context.
+func auth(a Authenticator, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		const authBasic = "Basic"
-		var (
-			pair  [][]byte
-			delim = []byte(":")
-
-			h = r.Header.Get("Authorization")
-		)
-
-		// Basic auth scheme.
-		if strings.HasPrefix(h, authBasic) {
-			payload, err := base64.StdEncoding.DecodeString(string(strings.Trim(h[len(authBasic):], " ")))
-			if err != nil {
-				sendErrorResponse(w, "invalid Base64 value in Basic Authorization header",
-					http.StatusUnauthorized, nil)
-				return
-			}
-
-			pair = bytes.SplitN(payload, delim, 2)
-		} else {
-			sendErrorResponse(w, "missing Basic Authorization header",
-				http.StatusUnauthorized, nil)
-			return
-
-		}
-
-		if len(pair) != 2 {
-			sendErrorResponse(w, "invalid value in Basic Authorization header",
-				http.StatusUnauthorized, nil)
-			return
-		}
-
-		var (
-			namespace = string(pair[0])
-			secret    = string(pair[1])
-		)
-		key, ok := authMap[namespace]
-		if !ok || key != secret {
-			sendErrorResponse(w, "invalid API credentials",
-				http.StatusUnauthorized, nil)
+		namespace, err := a.Authenticate(r)
+		if err != nil {
+			sendErrorResponse(w, otpgateway.ErrUnauthorized(err.Error()))
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), "namespace", namespace)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
-}
\ No newline at end of file
+}
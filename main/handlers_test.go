@@ -69,6 +69,19 @@ const (
 	dummyOTP       = "123456"
 )
 
+// apiErrResp mirrors the error envelope written by sendErrorResponse, so
+// tests can assert on the machine-readable `code` rather than the message.
+type apiErrResp struct {
+	Error struct {
+		Code    string `json:"code"`
+		Details struct {
+			Attempts    int     `json:"attempts"`
+			MaxAttempts int     `json:"max_attempts"`
+			TTLSeconds  float64 `json:"ttl_seconds"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
 var (
 	srv  *httptest.Server
 	rdis *miniredis.Miniredis
@@ -104,12 +117,26 @@ func init() {
 			Port: port,
 		}),
 	}
+	app.signingKeys = newKeyRing(app.store)
 
 	authCreds := map[string]string{dummyNamespace: dummySecret}
+	authr := &MultiAuthenticator{
+		Basic:  &BasicAuthenticator{Creds: authCreds},
+		HMAC:   NewHMACAuthenticator(nil, 5*time.Minute),
+		Bearer: NewBearerAuthenticator(nil),
+		Schemes: map[string]string{
+			dummyNamespace: "basic",
+		},
+	}
+
 	r := chi.NewRouter()
-	r.Get("/api/providers", auth(authCreds, wrap(app, handleGetProviders)))
-	r.Put("/api/otp/{id}", auth(authCreds, wrap(app, handleSetOTP)))
-	r.Post("/api/otp/{id}", auth(authCreds, wrap(app, handleCheckOTP)))
+	r.Get("/api/providers", auth(authr, wrap(app, handleGetProviders)))
+	r.Put("/api/otp/{id}", auth(authr, wrap(app, handleSetOTP)))
+	r.Post("/api/otp/{id}", auth(authr, wrap(app, handleCheckOTP)))
+	r.Post("/api/otp/{id}/receipt", auth(authr, wrap(app, handleVerifyReceipt)))
+	r.Get("/.well-known/jwks.json", wrap(app, handleJWKS))
+	r.Get("/api/webhooks/dead-letters", auth(authr, wrap(app, handleListDeadLetters)))
+	r.Post("/api/webhooks/dead-letters/{id}/replay", auth(authr, wrap(app, handleReplayDeadLetter)))
 	r.Get("/otp/{namespace}/{id}", wrap(app, handleIndex))
 	r.Post("/otp/{namespace}/{id}", wrap(app, handleIndex))
 	srv = httptest.NewServer(r)
@@ -189,9 +216,11 @@ func TestCheckOTP(t *testing.T) {
 
 	// Bad OTP.
 	cp.Set("otp", "123")
-	r = testRequest(t, http.MethodPost, "/api/otp/"+dummyOTPID, cp, &out)
+	var errOut apiErrResp
+	r = testRequest(t, http.MethodPost, "/api/otp/"+dummyOTPID, cp, &errOut)
 	assert.Equal(t, http.StatusBadRequest, r.StatusCode, "non 400 response for bad otp check")
-	assert.Equal(t, 2, data.Attempts, "attempts didn't increase")
+	assert.Equal(t, otpgateway.CodeOTPMismatch, errOut.Error.Code, "expected otp_mismatch code")
+	assert.Equal(t, 2, errOut.Error.Details.Attempts, "attempts didn't increase")
 
 	// Good OTP.
 	cp.Set("otp", dummyOTP)
@@ -199,6 +228,247 @@ func TestCheckOTP(t *testing.T) {
 	assert.Equal(t, http.StatusOK, r.StatusCode, "good OTP failed")
 }
 
+func TestCheckOTPReceipt(t *testing.T) {
+	rdis.FlushDB()
+	var (
+		checkOut = &checkResp{}
+		out      = httpResp{Data: checkOut}
+		p        = url.Values{}
+	)
+	p.Set("id", dummyOTPID)
+	p.Set("otp", dummyOTP)
+	p.Set("to", dummyToAddress)
+	p.Set("provider", dummyProvider)
+
+	r := testRequest(t, http.MethodPut, "/api/otp/"+dummyOTPID, p, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "otp registration failed")
+
+	cp := url.Values{}
+	cp.Set("otp", dummyOTP)
+	r = testRequest(t, http.MethodPost, "/api/otp/"+dummyOTPID, cp, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "otp check failed")
+	assert.True(t, checkOut.Verified, "otp wasn't marked verified")
+	assert.NotEqual(t, "", checkOut.Receipt, "no receipt was issued")
+
+	// The receipt should verify successfully against the JWKS-backed key.
+	var verifyOut httpResp
+	rp := url.Values{}
+	rp.Set("token", checkOut.Receipt)
+	r = testRequest(t, http.MethodPost, "/api/otp/"+dummyOTPID+"/receipt", rp, &verifyOut)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "receipt verification failed")
+}
+
+func TestStorePersistsOTPValue(t *testing.T) {
+	rdis.FlushDB()
+
+	namespace, id := dummyNamespace, dummyOTPID
+	app := testApp()
+
+	_, err := app.store.Set(namespace, id, otpgateway.OTP{
+		OTP:         dummyOTP,
+		To:          dummyToAddress,
+		Provider:    dummyProvider,
+		TTL:         10 * time.Second,
+		MaxAttempts: 3,
+	})
+	assert.NoError(t, err, "setting OTP failed")
+
+	stored, err := app.store.Check(namespace, id, false)
+	assert.NoError(t, err, "checking OTP failed")
+	assert.Equal(t, dummyOTP, stored.OTP, "OTP value should round-trip through the store, not just the in-memory return value")
+}
+
+func TestResendRotatesNonce(t *testing.T) {
+	rdis.FlushDB()
+
+	namespace, id := dummyNamespace, dummyOTPID
+	app := testApp()
+
+	otp, err := app.store.Set(namespace, id, otpgateway.OTP{
+		OTP:         dummyOTP,
+		To:          dummyToAddress,
+		Provider:    dummyProvider,
+		TTL:         10 * time.Second,
+		MaxAttempts: 3,
+	})
+	assert.NoError(t, err, "setting OTP failed")
+	assert.Equal(t, 1, otp.NonceSeq, "initial nonce_seq should be 1")
+
+	oldNonce := otp.Nonce
+
+	rotated, err := app.store.Rotate(namespace, id)
+	assert.NoError(t, err, "rotating OTP failed")
+	assert.Equal(t, 2, rotated.NonceSeq, "nonce_seq should increment on resend")
+	assert.NotEqual(t, oldNonce, rotated.Nonce, "nonce should change on resend")
+
+	// The old nonce must no longer be accepted.
+	_, err = checkOTPNonce(namespace, id, oldNonce, app)
+	assert.Error(t, err, "stale nonce should be rejected")
+
+	// The rotated nonce should verify successfully.
+	out, err := checkOTPNonce(namespace, id, rotated.Nonce, app)
+	assert.NoError(t, err, "current nonce should be accepted")
+	assert.True(t, out.Closed, "OTP should be closed after a successful check")
+}
+
+func TestTOTPRoundTrip(t *testing.T) {
+	secret, err := generateSecret()
+	assert.NoError(t, err, "generating secret failed")
+
+	now := time.Now().Unix()
+	code, err := otpgateway.TOTP(secret, now, 0, 30, 6, otpgateway.AlgoSHA1)
+	assert.NoError(t, err, "generating TOTP code failed")
+
+	assert.True(t, otpgateway.CheckTOTP(secret, now, 0, 30, 6, totpSkewWindow, otpgateway.AlgoSHA1, code),
+		"a freshly generated TOTP code should validate")
+	assert.False(t, otpgateway.CheckTOTP(secret, now, 0, 30, 6, totpSkewWindow, otpgateway.AlgoSHA1, "000000"),
+		"an unrelated code should not validate")
+}
+
+func TestSetAndCheckTOTP(t *testing.T) {
+	rdis.FlushDB()
+
+	var (
+		data = &otpResp{}
+		out  = httpResp{Data: data}
+		p    = url.Values{}
+	)
+	p.Set("id", dummyOTPID)
+	p.Set("to", dummyToAddress)
+	p.Set("provider", dummyProvider)
+	p.Set("kind", otpgateway.KindTOTP)
+
+	r := testRequest(t, http.MethodPut, "/api/otp/"+dummyOTPID, p, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "totp registration failed")
+	assert.Equal(t, otpgateway.KindTOTP, data.OTP.OTPKind, "otp_kind doesn't match")
+	assert.NotEqual(t, "", data.ProvisioningURI, "provisioning URI wasn't generated")
+	assert.NotEqual(t, "", data.QR, "QR code wasn't rendered")
+
+	app := testApp()
+	stored, err := app.store.Check(dummyNamespace, dummyOTPID, false)
+	assert.NoError(t, err, "fetching stored TOTP failed")
+
+	code, err := otpgateway.TOTP(stored.Secret, time.Now().Unix(), 0, int64(stored.Period), stored.Digits, stored.Algo)
+	assert.NoError(t, err, "generating TOTP code failed")
+
+	var checkOut = &checkResp{}
+	cp := url.Values{}
+	cp.Set("otp", code)
+	r = testRequest(t, http.MethodPost, "/api/otp/"+dummyOTPID, cp, &httpResp{Data: checkOut})
+	assert.Equal(t, http.StatusOK, r.StatusCode, "totp check failed")
+	assert.True(t, checkOut.Verified, "totp wasn't marked verified")
+}
+
+func TestSetAndCheckHOTP(t *testing.T) {
+	rdis.FlushDB()
+
+	var (
+		data = &otpResp{}
+		out  = httpResp{Data: data}
+		p    = url.Values{}
+	)
+	p.Set("id", dummyOTPID)
+	p.Set("to", dummyToAddress)
+	p.Set("provider", dummyProvider)
+	p.Set("kind", otpgateway.KindHOTP)
+
+	r := testRequest(t, http.MethodPut, "/api/otp/"+dummyOTPID, p, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "hotp registration failed")
+	assert.Equal(t, otpgateway.KindHOTP, data.OTP.OTPKind, "otp_kind doesn't match")
+	assert.NotEqual(t, "", data.ProvisioningURI, "provisioning URI wasn't generated")
+
+	app := testApp()
+	stored, err := app.store.Check(dummyNamespace, dummyOTPID, false)
+	assert.NoError(t, err, "fetching stored HOTP failed")
+
+	code, err := otpgateway.HOTP(stored.Secret, stored.Counter, stored.Digits, stored.Algo)
+	assert.NoError(t, err, "generating HOTP code failed")
+
+	var checkOut = &checkResp{}
+	cp := url.Values{}
+	cp.Set("otp", code)
+	r = testRequest(t, http.MethodPost, "/api/otp/"+dummyOTPID, cp, &httpResp{Data: checkOut})
+	assert.Equal(t, http.StatusOK, r.StatusCode, "hotp check failed")
+	assert.True(t, checkOut.Verified, "hotp wasn't marked verified")
+}
+
+func TestAuthSchemeMismatch(t *testing.T) {
+	authr := &MultiAuthenticator{
+		Basic: &BasicAuthenticator{Creds: map[string]string{dummyNamespace: dummySecret}},
+		Schemes: map[string]string{
+			dummyNamespace: "hmac",
+		},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.SetBasicAuth(dummyNamespace, dummySecret)
+
+	_, err := authr.Authenticate(req)
+	assert.Error(t, err, "a namespace configured for hmac should reject valid basic credentials")
+}
+
+func TestWebhookDeadLetterAndReplay(t *testing.T) {
+	rdis.FlushDB()
+
+	var (
+		calls   int
+		succeed bool
+	)
+	cb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("X-OTPGateway-Signature") == "" {
+			t.Error("webhook request is missing its signature header")
+		}
+		if !succeed {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer cb.Close()
+
+	app := testApp()
+	app.webhooks = NewWebhookDispatcher(map[string]WebhookConfig{
+		dummyNamespace: {URL: cb.URL, Secret: "whsec"},
+	}, app.store, logger, 1)
+	app.webhooks.MaxRetries = 1
+
+	app.webhooks.Emit(dummyNamespace, otpgateway.EventOTPCreated, map[string]string{"id": dummyOTPID})
+
+	// The callback fails, so the delivery should end up dead-lettered.
+	var letters []otpgateway.DeadLetter
+	for i := 0; i < 50; i++ {
+		letters, _ = app.store.ListDeadLetters(dummyNamespace)
+		if len(letters) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(letters), "expected one dead-lettered webhook")
+	assert.Equal(t, 1, calls, "expected exactly one delivery attempt before dead-lettering")
+
+	// Replaying after the callback recovers should succeed and clear it.
+	succeed = true
+	assert.NoError(t, app.webhooks.replay(dummyNamespace, letters[0].ID))
+
+	remaining, _ := app.store.ListDeadLetters(dummyNamespace)
+	assert.Equal(t, 0, len(remaining), "dead letter should be removed after a successful replay")
+}
+
+func testApp() *App {
+	return &App{
+		logger:         logger,
+		otpTTL:         10 * time.Second,
+		otpMaxAttempts: 3,
+		store:          otpgateway.NewRedisStore(otpgateway.RedisConf{Host: rdis.Host(), Port: mustAtoi(rdis.Port())}),
+	}
+}
+
+func mustAtoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
 func testRequest(t *testing.T, method, path string, p url.Values, out interface{}) *http.Response {
 	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(p.Encode()))
 	if err != nil {
@@ -228,4 +498,4 @@ func testRequest(t *testing.T, method, path string, p url.Values, out interface{
 	}
 
 	return resp
-}
\ No newline at end of file
+}
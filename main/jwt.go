@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/otpgateway"
+)
+
+const (
+	signingKeyName = "jwt_signing_key"
+	receiptTTL     = 5 * time.Minute
+)
+
+// jwtHeaderB64 is the base64url-encoded, fixed RS256 JWT header used for
+// every verification receipt.
+var jwtHeaderB64 = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+// receiptClaims is the payload embedded in a verification receipt JWT.
+type receiptClaims struct {
+	Issuer    string `json:"iss"`
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	Provider  string `json:"provider"`
+	IAT       int64  `json:"iat"`
+	EXP       int64  `json:"exp"`
+	JTI       string `json:"jti"`
+}
+
+// keyRing lazily generates and caches one RSA signing keypair per
+// namespace, persisting it to the store so receipts keep verifying across
+// restarts.
+type keyRing struct {
+	mu    sync.Mutex
+	store otpgateway.Store
+	keys  map[string]*rsa.PrivateKey
+}
+
+func newKeyRing(store otpgateway.Store) *keyRing {
+	return &keyRing{store: store, keys: make(map[string]*rsa.PrivateKey)}
+}
+
+// get returns the signing key for a namespace, generating and persisting a
+// new RSA-2048 keypair the first time the namespace is seen.
+func (k *keyRing) get(namespace string) (*rsa.PrivateKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[namespace]; ok {
+		return key, nil
+	}
+
+	if b, err := k.store.GetKey(namespace, signingKeyName); err == nil {
+		key, err := x509.ParsePKCS1PrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing stored signing key: %v", err)
+		}
+		k.keys[namespace] = key
+		return key, nil
+	} else if err != otpgateway.ErrNotExist {
+		return nil, fmt.Errorf("error loading signing key: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("error generating signing key: %v", err)
+	}
+	if err := k.store.SetKey(namespace, signingKeyName, x509.MarshalPKCS1PrivateKey(key)); err != nil {
+		return nil, fmt.Errorf("error persisting signing key: %v", err)
+	}
+	k.keys[namespace] = key
+	return key, nil
+}
+
+// namespaces returns every namespace with a signing key persisted in the
+// store, not just those cached in this process, so a freshly restarted
+// process still advertises keys for namespaces it hasn't served yet.
+func (k *keyRing) namespaces() ([]string, error) {
+	return k.store.KeyNamespaces(signingKeyName)
+}
+
+// jwk returns the JSON Web Key representation of a namespace's public key.
+func (k *keyRing) jwk(namespace string) (map[string]string, error) {
+	key, err := k.get(namespace)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.PublicKey
+	return map[string]string{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": namespace,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}, nil
+}
+
+// newReceipt mints a signed JWT verification receipt for a successfully
+// checked OTP.
+func newReceipt(app *App, otp otpgateway.OTP) (string, error) {
+	key, err := app.signingKeys.get(otp.Namespace)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := generateRandomString(24, alphaNumChars)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	body, err := json.Marshal(receiptClaims{
+		Issuer:    app.RootURL,
+		Namespace: otp.Namespace,
+		ID:        otp.ID,
+		To:        otp.To,
+		Provider:  otp.Provider,
+		IAT:       now.Unix(),
+		EXP:       now.Add(receiptTTL).Unix(),
+		JTI:       jti,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := jwtHeaderB64 + "." + base64.RawURLEncoding.EncodeToString(body)
+	sig, err := signRS256(key, signingInput)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + sig, nil
+}
+
+// verifyReceipt validates a JWT receipt's signature, issuer, namespace
+// binding and expiry, returning its decoded claims.
+func verifyReceipt(app *App, namespace, token string) (receiptClaims, error) {
+	var claims receiptClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed receipt token")
+	}
+
+	key, err := app.signingKeys.get(namespace)
+	if err != nil {
+		return claims, err
+	}
+	if err := verifyRS256(&key.PublicKey, parts[0]+"."+parts[1], parts[2]); err != nil {
+		return claims, errors.New("receipt signature is invalid")
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errors.New("invalid receipt payload")
+	}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return claims, errors.New("invalid receipt payload")
+	}
+
+	if claims.Issuer != app.RootURL {
+		return claims, errors.New("receipt was not issued by this gateway")
+	}
+	if claims.Namespace != namespace {
+		return claims, errors.New("receipt was not issued for this namespace")
+	}
+	if time.Now().Unix() > claims.EXP {
+		return claims, errors.New("receipt has expired")
+	}
+
+	return claims, nil
+}
+
+func signRS256(key *rsa.PrivateKey, signingInput string) (string, error) {
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func verifyRS256(pub *rsa.PublicKey, signingInput, sig string) error {
+	sigBytes, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	hashed := sha256.Sum256([]byte(signingInput))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sigBytes)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+
+	"github.com/knadh/otpgateway"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// totpSkewWindow is the number of TOTP steps accepted on either side
+	// of the current one, to tolerate clock drift.
+	totpSkewWindow = 1
+
+	// hotpResyncWindow is the number of counter values scanned ahead of
+	// the stored one to tolerate a handful of unseen HOTP presses.
+	hotpResyncWindow = 10
+)
+
+// generateSecret returns a random base32-encoded shared secret suitable
+// for TOTP/HOTP provisioning.
+func generateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// provisioningURI builds the otpauth:// key URI an authenticator app uses
+// to enroll a TOTP/HOTP secret.
+func provisioningURI(issuer string, otp otpgateway.OTP) string {
+	label := fmt.Sprintf("%s:%s", issuer, otp.ID)
+
+	q := url.Values{}
+	q.Set("secret", otp.Secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", otp.Algo)
+	q.Set("digits", fmt.Sprintf("%d", otp.Digits))
+
+	kind := "totp"
+	if otp.OTPKind == otpgateway.KindHOTP {
+		kind = "hotp"
+		q.Set("counter", fmt.Sprintf("%d", otp.Counter))
+	} else {
+		q.Set("period", fmt.Sprintf("%d", otp.Period))
+	}
+
+	return fmt.Sprintf("otpauth://%s/%s?%s", kind, url.PathEscape(label), q.Encode())
+}
+
+// provisioningQR renders a provisioning URI as a base64-encoded PNG QR
+// code that a client can inline into an <img> tag.
+func provisioningQR(uri string) (string, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(png), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/knadh/otpgateway"
+)
+
+// WebhookConfig describes a namespace's registered event callback.
+type WebhookConfig struct {
+	URL    string
+	Secret string
+
+	// ToleranceWindow is the clock-skew allowance the receiver is
+	// expected to enforce against the signature's `t=` timestamp. It's
+	// carried here purely for operators to read back out of config.
+	ToleranceWindow time.Duration
+}
+
+// webhookJob is a single queued delivery attempt.
+type webhookJob struct {
+	namespace string
+	event     string
+	payload   interface{}
+}
+
+// webhookEnvelope is the JSON body POSTed to a namespace's callback URL.
+type webhookEnvelope struct {
+	Event     string      `json:"event"`
+	Namespace string      `json:"namespace"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDispatcher fires HMAC-signed webhooks for OTP lifecycle events
+// through a bounded worker pool. A delivery is retried with exponential
+// backoff and, once its retry budget is exhausted, parked on the
+// namespace's dead-letter list for manual inspection or replay.
+type WebhookDispatcher struct {
+	Namespaces map[string]WebhookConfig
+	Client     *http.Client
+	Store      otpgateway.Store
+	Logger     *log.Logger
+
+	Workers    int
+	MaxRetries int
+
+	jobs chan webhookJob
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher with its worker pool
+// running. Call Emit to queue a delivery.
+func NewWebhookDispatcher(namespaces map[string]WebhookConfig, store otpgateway.Store, logger *log.Logger, workers int) *WebhookDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &WebhookDispatcher{
+		Namespaces: namespaces,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Store:      store,
+		Logger:     logger,
+		Workers:    workers,
+		MaxRetries: 5,
+		jobs:       make(chan webhookJob, 100),
+	}
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	return d
+}
+
+// Emit queues a lifecycle event for delivery. It's a no-op if the
+// namespace has no callback URL configured. Emit never blocks the caller:
+// if the job queue is full (a slow or down receiver is backing up
+// deliveries), the event is dropped and logged rather than stalling the
+// OTP request that triggered it.
+func (d *WebhookDispatcher) Emit(namespace, event string, data interface{}) {
+	if d == nil {
+		return
+	}
+	if _, ok := d.Namespaces[namespace]; !ok {
+		return
+	}
+	select {
+	case d.jobs <- webhookJob{namespace: namespace, event: event, payload: data}:
+	default:
+		d.Logger.Printf("webhook queue full, dropping %s/%s event", namespace, event)
+	}
+}
+
+func (d *WebhookDispatcher) worker() {
+	for job := range d.jobs {
+		d.deliver(job)
+	}
+}
+
+// deliver attempts a single job, retrying with exponential backoff, and
+// parks it on the dead-letter list if every attempt fails.
+func (d *WebhookDispatcher) deliver(job webhookJob) {
+	cfg := d.Namespaces[job.namespace]
+
+	body, err := json.Marshal(webhookEnvelope{
+		Event:     job.event,
+		Namespace: job.namespace,
+		CreatedAt: time.Now().Unix(),
+		Data:      job.payload,
+	})
+	if err != nil {
+		d.Logger.Printf("error marshalling webhook payload for %s/%s: %v", job.namespace, job.event, err)
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	var lastErr error
+	for attempt := 1; attempt <= d.MaxRetries; attempt++ {
+		if err := d.send(cfg, body); err != nil {
+			lastErr = err
+			d.Logger.Printf("webhook delivery failed (attempt %d/%d) for %s/%s: %v",
+				attempt, d.MaxRetries, job.namespace, job.event, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+		return
+	}
+
+	if err := d.Store.PushDeadLetter(job.namespace, otpgateway.DeadLetter{
+		ID:        fmt.Sprintf("%s-%d", job.event, time.Now().UnixNano()),
+		Event:     job.event,
+		URL:       cfg.URL,
+		Body:      string(body),
+		Error:     lastErr.Error(),
+		Attempts:  d.MaxRetries,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		d.Logger.Printf("error parking dead-lettered webhook for %s/%s: %v", job.namespace, job.event, err)
+	}
+}
+
+// send performs one delivery attempt.
+func (d *WebhookDispatcher) send(cfg WebhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-OTPGateway-Signature", signWebhook(cfg.Secret, time.Now().Unix(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook produces a Stripe-style signature header:
+// "t=<unix>,v1=<hex(hmac_sha256(secret, t + "." + body))>".
+func signWebhook(secret string, t int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s", t, body)))
+	return fmt.Sprintf("t=%d,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// replay re-sends a parked dead letter's recorded body to the namespace's
+// currently configured callback URL, signed with its current secret, and
+// removes it from the dead-letter list on success.
+func (d *WebhookDispatcher) replay(namespace, id string) error {
+	letters, err := d.Store.ListDeadLetters(namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, letter := range letters {
+		if letter.ID != id {
+			continue
+		}
+		cfg := d.Namespaces[namespace]
+		if err := d.send(cfg, []byte(letter.Body)); err != nil {
+			return err
+		}
+		return d.Store.RemoveDeadLetter(namespace, id)
+	}
+	return otpgateway.ErrNotExist
+}
@@ -0,0 +1,334 @@
+package otpgateway
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisConf has Redis connection configuration.
+type RedisConf struct {
+	Host     string
+	Port     int
+	Password string
+	DB       int
+	MaxOpen  int
+	MaxIdle  int
+}
+
+// RedisStore is a Redis backed implementation of the Store interface.
+type RedisStore struct {
+	conf RedisConf
+	rd   *redis.Client
+}
+
+// NewRedisStore creates and returns a new RedisStore.
+func NewRedisStore(c RedisConf) *RedisStore {
+	return &RedisStore{
+		conf: c,
+		rd: redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+			Password: c.Password,
+			DB:       c.DB,
+		}),
+	}
+}
+
+func otpKey(namespace, id string) string {
+	return fmt.Sprintf("otp:%s:%s", namespace, id)
+}
+
+func namedKey(namespace, name string) string {
+	return fmt.Sprintf("key:%s:%s", namespace, name)
+}
+
+// keyNamespacesKey indexes the namespaces that have a value persisted
+// under a given key name, so they can be enumerated without scanning.
+func keyNamespacesKey(name string) string {
+	return fmt.Sprintf("key_namespaces:%s", name)
+}
+
+func deadLetterKey(namespace string) string {
+	return fmt.Sprintf("webhook_dead_letters:%s", namespace)
+}
+
+// newNonce generates a cryptographically random, URL-safe single-use
+// token for the check link.
+func newNonce() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// record is the Redis persistence representation of an OTP. OTP's own
+// json tags are tuned for HTTP responses (hiding the code, nonce and
+// TOTP/HOTP secret from callers); reusing them for storage would silently
+// drop those fields from every write. record carries every field that
+// needs to round-trip through Redis instead.
+type record struct {
+	Namespace   string `json:"namespace"`
+	ID          string `json:"id"`
+	OTP         string `json:"otp"`
+	To          string `json:"to"`
+	Description string `json:"description"`
+	Provider    string `json:"provider"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+	Closed      bool   `json:"closed"`
+	Nonce       string `json:"nonce"`
+	NonceSeq    int    `json:"nonce_seq"`
+	OTPKind     string `json:"otp_kind"`
+	Secret      string `json:"secret"`
+	Digits      int    `json:"digits"`
+	Period      int    `json:"period"`
+	Counter     uint64 `json:"counter"`
+	Algo        string `json:"algo"`
+}
+
+// newRecord captures every field of otp that needs to survive a round
+// trip through Redis.
+func newRecord(otp OTP) record {
+	return record{
+		Namespace:   otp.Namespace,
+		ID:          otp.ID,
+		OTP:         otp.OTP,
+		To:          otp.To,
+		Description: otp.Description,
+		Provider:    otp.Provider,
+		Attempts:    otp.Attempts,
+		MaxAttempts: otp.MaxAttempts,
+		Closed:      otp.Closed,
+		Nonce:       otp.Nonce,
+		NonceSeq:    otp.NonceSeq,
+		OTPKind:     otp.OTPKind,
+		Secret:      otp.Secret,
+		Digits:      otp.Digits,
+		Period:      otp.Period,
+		Counter:     otp.Counter,
+		Algo:        otp.Algo,
+	}
+}
+
+// otp rebuilds the OTP that was captured in r. TTL/TTLSeconds aren't
+// part of the record — they're derived from the Redis key's own expiry
+// after a read.
+func (r record) otp() OTP {
+	return OTP{
+		Namespace:   r.Namespace,
+		ID:          r.ID,
+		OTP:         r.OTP,
+		To:          r.To,
+		Description: r.Description,
+		Provider:    r.Provider,
+		Attempts:    r.Attempts,
+		MaxAttempts: r.MaxAttempts,
+		Closed:      r.Closed,
+		Nonce:       r.Nonce,
+		NonceSeq:    r.NonceSeq,
+		OTPKind:     r.OTPKind,
+		Secret:      r.Secret,
+		Digits:      r.Digits,
+		Period:      r.Period,
+		Counter:     r.Counter,
+		Algo:        r.Algo,
+	}
+}
+
+// Set writes an OTP to the store, overwriting any existing record, and
+// mints its first nonce.
+func (s *RedisStore) Set(namespace, id string, otp OTP) (OTP, error) {
+	otp.Namespace = namespace
+	otp.ID = id
+	otp.Attempts++
+
+	nonce, err := newNonce()
+	if err != nil {
+		return otp, err
+	}
+	otp.Nonce = nonce
+	otp.NonceSeq = 1
+
+	b, err := json.Marshal(newRecord(otp))
+	if err != nil {
+		return otp, err
+	}
+	if err := s.rd.Set(otpKey(namespace, id), b, otp.TTL).Err(); err != nil {
+		return otp, err
+	}
+	return otp, nil
+}
+
+// Check retrieves an OTP, optionally incrementing its attempt counter.
+func (s *RedisStore) Check(namespace, id string, incrementAttempt bool) (OTP, error) {
+	var rec record
+
+	b, err := s.rd.Get(otpKey(namespace, id)).Bytes()
+	if err == redis.Nil {
+		return OTP{}, ErrNotExist
+	} else if err != nil {
+		return OTP{}, err
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return OTP{}, err
+	}
+	otp := rec.otp()
+
+	ttl, err := s.rd.TTL(otpKey(namespace, id)).Result()
+	if err == nil {
+		otp.TTL = ttl
+		otp.TTLSeconds = ttl.Seconds()
+	}
+
+	if incrementAttempt {
+		otp.Attempts++
+		b, err := json.Marshal(newRecord(otp))
+		if err != nil {
+			return otp, err
+		}
+		if err := s.rd.Set(otpKey(namespace, id), b, ttl).Err(); err != nil {
+			return otp, err
+		}
+	}
+
+	return otp, nil
+}
+
+// Rotate issues a fresh single-use nonce for an existing OTP, invalidating
+// the previous one, and counts the rotation as an attempt so a resend
+// still flows through the existing attempts quota.
+func (s *RedisStore) Rotate(namespace, id string) (OTP, error) {
+	otp, err := s.Check(namespace, id, false)
+	if err != nil {
+		return otp, err
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return otp, err
+	}
+	otp.Nonce = nonce
+	otp.NonceSeq++
+	otp.Attempts++
+
+	b, err := json.Marshal(newRecord(otp))
+	if err != nil {
+		return otp, err
+	}
+	if err := s.rd.Set(otpKey(namespace, id), b, otp.TTL).Err(); err != nil {
+		return otp, err
+	}
+	return otp, nil
+}
+
+// SetCounter persists an updated HOTP counter after a successful resync.
+func (s *RedisStore) SetCounter(namespace, id string, counter uint64) error {
+	otp, err := s.Check(namespace, id, false)
+	if err != nil {
+		return err
+	}
+	otp.Counter = counter
+
+	b, err := json.Marshal(newRecord(otp))
+	if err != nil {
+		return err
+	}
+	return s.rd.Set(otpKey(namespace, id), b, otp.TTL).Err()
+}
+
+// Close marks an OTP as verified and closed.
+func (s *RedisStore) Close(namespace, id string) error {
+	otp, err := s.Check(namespace, id, false)
+	if err != nil {
+		return err
+	}
+	otp.Closed = true
+
+	b, err := json.Marshal(newRecord(otp))
+	if err != nil {
+		return err
+	}
+	return s.rd.Set(otpKey(namespace, id), b, otp.TTL).Err()
+}
+
+// SetKey persists an arbitrary named value under a namespace, with no
+// expiry, so it can be recalled across restarts, and records the
+// namespace in that key name's index so it can later be enumerated via
+// KeyNamespaces.
+func (s *RedisStore) SetKey(namespace, name string, val []byte) error {
+	if err := s.rd.Set(namedKey(namespace, name), val, 0).Err(); err != nil {
+		return err
+	}
+	return s.rd.SAdd(keyNamespacesKey(name), namespace).Err()
+}
+
+// GetKey retrieves a value previously persisted with SetKey.
+func (s *RedisStore) GetKey(namespace, name string) ([]byte, error) {
+	b, err := s.rd.Get(namedKey(namespace, name)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotExist
+	}
+	return b, err
+}
+
+// KeyNamespaces returns every namespace that has a value persisted under
+// name via SetKey.
+func (s *RedisStore) KeyNamespaces(name string) ([]string, error) {
+	return s.rd.SMembers(keyNamespacesKey(name)).Result()
+}
+
+// PushDeadLetter parks a failed webhook delivery on the namespace's
+// dead-letter list.
+func (s *RedisStore) PushDeadLetter(namespace string, d DeadLetter) error {
+	d.Namespace = namespace
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.rd.RPush(deadLetterKey(namespace), b).Err()
+}
+
+// ListDeadLetters returns every delivery parked on a namespace's
+// dead-letter list, oldest first.
+func (s *RedisStore) ListDeadLetters(namespace string) ([]DeadLetter, error) {
+	raw, err := s.rd.LRange(deadLetterKey(namespace), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]DeadLetter, 0, len(raw))
+	for _, b := range raw {
+		var d DeadLetter
+		if err := json.Unmarshal([]byte(b), &d); err != nil {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// RemoveDeadLetter removes the first parked delivery matching id from a
+// namespace's dead-letter list.
+func (s *RedisStore) RemoveDeadLetter(namespace, id string) error {
+	letters, err := s.ListDeadLetters(namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range letters {
+		if d.ID != id {
+			continue
+		}
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return s.rd.LRem(deadLetterKey(namespace), 1, b).Err()
+	}
+	return ErrNotExist
+}
@@ -0,0 +1,98 @@
+package otpgateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// OTPKind selects how an OTP's value is generated and validated.
+const (
+	KindRandom = "random"
+	KindTOTP   = "totp"
+	KindHOTP   = "hotp"
+)
+
+// Supported HMAC algorithms for HOTP/TOTP generation, per RFC 6238 §1.2.
+const (
+	AlgoSHA1   = "SHA1"
+	AlgoSHA256 = "SHA256"
+	AlgoSHA512 = "SHA512"
+)
+
+func newHash(algo string) func() hash.Hash {
+	switch algo {
+	case AlgoSHA256:
+		return sha256.New
+	case AlgoSHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// HOTP implements the HMAC-SHA truncation from RFC 4226 §5.3 for a
+// base32-encoded shared secret and counter value.
+func HOTP(secret string, counter uint64, digits int, algo string) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %v", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(newHash(algo), key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := (uint32(sum[offset])&0x7f)<<24 |
+		uint32(sum[offset+1])<<16 |
+		uint32(sum[offset+2])<<8 |
+		uint32(sum[offset+3])
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod), nil
+}
+
+// CheckHOTP validates a counter-based code against a resync window
+// (RFC 4226 §7.4), returning the counter to persist for next time.
+func CheckHOTP(secret string, counter uint64, digits int, algo string, resyncWindow int, code string) (next uint64, ok bool) {
+	for i := 0; i <= resyncWindow; i++ {
+		c := counter + uint64(i)
+		want, err := HOTP(secret, c, digits, algo)
+		if err == nil && want == code {
+			return c + 1, true
+		}
+	}
+	return counter, false
+}
+
+// TOTP computes the time-based code from RFC 6238 for a given Unix
+// timestamp, epoch (t0) and step size, both in seconds.
+func TOTP(secret string, now, t0, step int64, digits int, algo string) (string, error) {
+	return HOTP(secret, uint64((now-t0)/step), digits, algo)
+}
+
+// CheckTOTP validates a time-based code, accepting it if it matches any
+// step within +/- window steps of now.
+func CheckTOTP(secret string, now, t0, step int64, digits, window int, algo string, code string) bool {
+	counter := (now - t0) / step
+	for i := -window; i <= window; i++ {
+		want, err := HOTP(secret, uint64(counter+int64(i)), digits, algo)
+		if err == nil && want == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package otpgateway
+
+import "time"
+
+// OTP represents a single OTP record along with its verification metadata.
+type OTP struct {
+	Namespace   string        `json:"namespace"`
+	ID          string        `json:"id"`
+	OTP         string        `json:"-"`
+	To          string        `json:"to"`
+	Description string        `json:"description"`
+	Provider    string        `json:"provider"`
+	Attempts    int           `json:"attempts"`
+	MaxAttempts int           `json:"max_attempts"`
+	TTL         time.Duration `json:"-"`
+	TTLSeconds  float64       `json:"ttl_seconds"`
+	Closed      bool          `json:"closed"`
+
+	// Nonce is the single-use token embedded in the pushed check URL. It
+	// changes on every Set and every resend so a leaked link can't be
+	// replayed once a newer one has been issued.
+	Nonce string `json:"-"`
+
+	// NonceSeq is a monotonic counter bumped alongside Nonce, exposed to
+	// callers so they can detect out-of-order or replayed resend clicks.
+	NonceSeq int `json:"nonce_seq"`
+
+	// OTPKind selects how the OTP value is generated and validated:
+	// KindRandom (default), KindTOTP or KindHOTP.
+	OTPKind string `json:"otp_kind"`
+
+	// Secret is the base32-encoded shared secret used for TOTP/HOTP.
+	Secret string `json:"-"`
+
+	// Digits is the number of digits an TOTP/HOTP code produces.
+	Digits int `json:"-"`
+
+	// Period is the TOTP time-step, in seconds.
+	Period int `json:"-"`
+
+	// Counter is the current HOTP counter value.
+	Counter uint64 `json:"-"`
+
+	// Algo is the HMAC algorithm (AlgoSHA1/256/512) used for TOTP/HOTP.
+	Algo string `json:"-"`
+}
+
+// Provider represents a messaging channel (SMS, e-mail etc.) capable of
+// delivering an OTP to a recipient.
+type Provider interface {
+	ID() string
+	ChannelName() string
+	Description() string
+	ValidateAddress(to string) error
+	Push(toAddr string, subject string, m []byte) error
+	MaxOTPLen() int
+	MaxBodyLen() int
+}
+
+// Store represents the storage backend that persists OTPs.
+type Store interface {
+	Set(namespace, id string, otp OTP) (OTP, error)
+	Check(namespace, id string, incrementAttempt bool) (OTP, error)
+	Close(namespace, id string) error
+
+	// Rotate issues a fresh single-use nonce for an existing OTP,
+	// invalidating the previous one, and bumps its nonce sequence.
+	Rotate(namespace, id string) (OTP, error)
+
+	// SetCounter persists an updated HOTP counter after a successful
+	// resync.
+	SetCounter(namespace, id string, counter uint64) error
+
+	// SetKey persists an arbitrary named value (for instance, a signing
+	// key) under a namespace so that it can be recalled across restarts.
+	SetKey(namespace, name string, val []byte) error
+
+	// GetKey retrieves a value previously persisted with SetKey.
+	GetKey(namespace, name string) ([]byte, error)
+
+	// KeyNamespaces returns every namespace that has a value persisted
+	// under name via SetKey, so callers can enumerate persisted state
+	// (e.g. signing keys) without depending on an in-memory cache.
+	KeyNamespaces(name string) ([]string, error)
+
+	// PushDeadLetter parks a webhook delivery that exhausted its retry
+	// budget, for later inspection or replay.
+	PushDeadLetter(namespace string, d DeadLetter) error
+
+	// ListDeadLetters returns a namespace's parked webhook deliveries.
+	ListDeadLetters(namespace string) ([]DeadLetter, error)
+
+	// RemoveDeadLetter removes a parked delivery, typically after it's
+	// been replayed successfully.
+	RemoveDeadLetter(namespace, id string) error
+}